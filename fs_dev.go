@@ -0,0 +1,20 @@
+//go:build dev
+
+package viewkit
+
+import (
+	"io/fs"
+	"os"
+)
+
+const devMode = true
+
+// liveFS ignores the filesystems passed to New and reads templates and
+// static assets straight off disk so edits show up without a rebuild.
+// Rooted at the process working directory, not at static/templates
+// themselves, so paths resolve the same way as the embedded production FS
+// (which embeds the static and templates directories whole).
+func liveFS(_, _ fs.FS) (fs.FS, fs.FS) {
+	cwd := os.DirFS(".")
+	return cwd, cwd
+}
@@ -0,0 +1,11 @@
+//go:build !dev
+
+package viewkit
+
+import "io/fs"
+
+const devMode = false
+
+func liveFS(static, templates fs.FS) (fs.FS, fs.FS) {
+	return static, templates
+}
@@ -1,14 +1,21 @@
 package viewkit
 
 import (
+	"bytes"
 	"embed"
 	"fmt"
+	"hash/fnv"
 	"html/template"
+	"io"
 	"io/fs"
 	"log/slog"
 	"net/http"
 	"path"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	texttemplate "text/template"
 )
 
 //go:embed style.css main.gohtml
@@ -22,18 +29,40 @@ const (
 
 const innerHTML = `{{ define "inner" }}
 <!DOCTYPE html>
-<html>
+<html{{ with .Theme }} data-theme="{{ . }}"{{ end }}>
   <head>
     %s<link rel="stylesheet" href="/viewkit/style.css">%s
   </head>
   <body>
-    {{- template "body" . }}
+    {{- .Body }}
   </body>
 </html>
 {{ end }}`
 
-func loader(readDir func(string) ([]fs.DirEntry, error), folder, suffix string) []string {
-	entries, err := readDir(folder)
+const themeCookie = "theme"
+
+const (
+	fragmentHeader = "X-View-Fragment"
+	fragmentQuery  = "fragment"
+)
+
+// NamedFragment lets a view's data function request multiple out-of-band
+// swaps in one response: each entry is rendered in sequence against the
+// template block matching its Name, e.g. {{ define "fragment-foo" }}.
+type NamedFragment struct {
+	Name string
+	Data any
+}
+
+func fragmentName(r *http.Request) string {
+	if name := r.Header.Get(fragmentHeader); name != "" {
+		return name
+	}
+	return r.URL.Query().Get(fragmentQuery)
+}
+
+func loader(fsys fs.FS, folder, suffix string) []string {
+	entries, err := fs.ReadDir(fsys, folder)
 	if err != nil {
 		return nil
 	}
@@ -44,7 +73,7 @@ func loader(readDir func(string) ([]fs.DirEntry, error), folder, suffix string)
 		name := entry.Name()
 
 		if entry.IsDir() {
-			loader(readDir, folder+"/"+name, suffix)
+			loader(fsys, folder+"/"+name, suffix)
 			continue
 		}
 
@@ -60,14 +89,25 @@ func loader(readDir func(string) ([]fs.DirEntry, error), folder, suffix string)
 	return out
 }
 
-func loadStyles(static embed.FS) (styles string) {
-	entries := loader(static.ReadDir, "static", ".css")
+func loadStyles(static fs.FS) (styles string) {
+	entries := loader(static, "static", ".css")
 	for _, entry := range entries {
 		styles += "\n\t" + "<link rel=\"stylesheet\" href=\"/" + entry + "\">"
 	}
 	return
 }
 
+func loadThemeStyles(themes []string) (styles string) {
+	for _, name := range themes {
+		styles += "\n\t" + "<link rel=\"stylesheet\" href=\"" + themepath(name) + "/style.css\">"
+	}
+	return
+}
+
+func themepath(name string) string {
+	return path.Join("/static/themes", name)
+}
+
 func wrapTitle(s string) string {
 	if s == "" {
 		return s
@@ -78,47 +118,271 @@ func wrapTitle(s string) string {
 type Viewer interface {
 	AddSource(name string, data func(*http.Request) any)
 	AddView(name, tmpl string, data func(*http.Request) any)
+	AddTextView(name, contentType, tmpl string, data func(*http.Request) any)
+	AddTheme(name string, fsys fs.FS)
+	AddPartial(name, tmpl string)
+	AddFuncMap(fm template.FuncMap)
 	Inject(router *http.ServeMux)
 }
 
 type Configuration struct {
-	Path      string
-	Title     string
-	StartView string
-	FuncMap   template.FuncMap
+	Path         string
+	Title        string
+	StartView    string
+	FuncMap      template.FuncMap
+	Themes       []string
+	DefaultTheme string
+	CachePolicy  CachePolicy
+}
+
+// CachePolicy sets the Cache-Control max-age, in seconds, applied to each
+// route class. Zero disables caching for that class, except /favicon.ico,
+// which then falls back to a max-age of 86400. Ignored in dev builds
+// (-tags dev), where templates and assets are re-read on every request.
+type CachePolicy struct {
+	Views    int
+	Static   int
+	Internal int
 }
 
-func New(cfg Configuration, static, templates embed.FS) Viewer {
+func New(cfg Configuration, static, templates fs.FS) Viewer {
 	cfg.Path = strings.Trim(path.Clean(cfg.Path), "/")
+	if cfg.FuncMap == nil {
+		cfg.FuncMap = template.FuncMap{}
+	}
 	cfg.FuncMap["basepath"] = func() string { return cfg.Path }
-	return &viewer{
+	cfg.FuncMap["themepath"] = themepath
+
+	staticFS, templatesFS := liveFS(static, templates)
+
+	v := &viewer{
 		cfg:       cfg,
-		inner:     fmt.Sprintf(innerHTML, wrapTitle(cfg.Title), loadStyles(static)),
-		views:     make(map[string]http.HandlerFunc),
+		inner:     fmt.Sprintf(innerHTML, wrapTitle(cfg.Title), loadStyles(staticFS)+loadThemeStyles(cfg.Themes)),
+		views:     make(map[string]*viewEntry),
 		sources:   make(map[string]func(*http.Request) any),
-		static:    static,
-		templates: templates,
+		themes:    make(map[string]fs.FS),
+		static:    staticFS,
+		templates: templatesFS,
 	}
+
+	v.loadPartials()
+
+	return v
 }
 
 type viewer struct {
 	cfg       Configuration
 	inner     string
-	views     map[string]http.HandlerFunc
+	views     map[string]*viewEntry
 	sources   map[string]func(*http.Request) any
-	static    embed.FS
-	templates embed.FS
+	themes    map[string]fs.FS
+	partials  *template.Template
+	static    fs.FS
+	templates fs.FS
+}
+
+// cloneBase returns the shared partials template, cloned for a view to parse
+// its own body on top of, so any view can reference any registered partial
+// via {{ template "name" . }}.
+func (v *viewer) cloneBase() *template.Template {
+	if v.partials != nil {
+		return template.Must(v.partials.Clone())
+	}
+	return template.New(inner).Funcs(v.cfg.FuncMap)
+}
+
+func (v *viewer) AddPartial(name, tmpl string) {
+	// The root template must be named something other than a real partial
+	// name: html/template.Template.New returns a fresh, unlinked template
+	// when called with the receiver's own name, so a partial that happened
+	// to share its name with the root would silently fail to register.
+	if v.partials == nil {
+		v.partials = template.New(inner).Funcs(v.cfg.FuncMap)
+	}
+	template.Must(v.partials.New(name).Parse(tmpl))
+}
+
+func (v *viewer) AddFuncMap(fm template.FuncMap) {
+	for name, fn := range fm {
+		v.cfg.FuncMap[name] = fn
+	}
+	if v.partials != nil {
+		v.partials.Funcs(v.cfg.FuncMap)
+	}
+}
+
+func (v *viewer) loadPartials() {
+	for _, entry := range loader(v.templates, "templates", ".gohtml") {
+		name := path.Base(entry)
+		if !strings.HasPrefix(name, "_") {
+			continue
+		}
+
+		// fs.ReadFile + Parse, not ParseFS: ParseFS names the resulting
+		// template after the file's base name regardless of the name New
+		// was given, so it would register this as "_nav.gohtml" instead of
+		// the "_nav" views actually reference.
+		b, err := fs.ReadFile(v.templates, entry)
+		if err != nil {
+			continue
+		}
+
+		if v.partials == nil {
+			v.partials = template.New(inner).Funcs(v.cfg.FuncMap)
+		}
+		template.Must(v.partials.New(strings.TrimSuffix(name, path.Ext(name))).Parse(string(b)))
+	}
+}
+
+func (v *viewer) activeTheme(r *http.Request) string {
+	if len(v.cfg.Themes) == 0 {
+		return ""
+	}
+
+	name := r.URL.Query().Get("theme")
+	if name == "" {
+		if c, err := r.Cookie(themeCookie); err == nil {
+			name = c.Value
+		}
+	}
+
+	if slices.Contains(v.cfg.Themes, name) {
+		return name
+	}
+
+	return v.cfg.DefaultTheme
+}
+
+// templateExecutor is satisfied by both *html/template.Template and
+// *text/template.Template, letting viewEntry serve HTML views and plain-text
+// output formats (JSON, CSV, ...) through the same dispatch path.
+type templateExecutor interface {
+	ExecuteTemplate(wr io.Writer, name string, data any) error
+}
+
+// viewEntry holds the template backing a registered view. In a dev build
+// (-tags dev) the template is re-parsed from the live filesystem on every
+// request so edits to templates/*.gohtml are picked up without a rebuild;
+// otherwise it is parsed once and reused. File partials (templates/_*.gohtml)
+// are read once, by New, and are not re-read in either build.
+type viewEntry struct {
+	mu          sync.RWMutex
+	tmpl        templateExecutor
+	build       func() templateExecutor
+	execName    string
+	contentType string
+	data        func(*http.Request) any
+	v           *viewer
+}
+
+func (vw *viewEntry) template() templateExecutor {
+	if !devMode {
+		vw.mu.RLock()
+		defer vw.mu.RUnlock()
+		return vw.tmpl
+	}
+
+	vw.mu.Lock()
+	defer vw.mu.Unlock()
+	vw.tmpl = vw.build()
+	return vw.tmpl
+}
+
+func contentETag(b []byte) string {
+	h := fnv.New64a()
+	_, _ = h.Write(b)
+	return `"` + strconv.FormatUint(h.Sum64(), 16) + `"`
+}
+
+// render runs execFn and writes its output to w. When a view cache max-age
+// is configured (and this isn't a dev build) it buffers the output first to
+// compute a content-hash ETag, honouring If-None-Match with a 304 instead of
+// rewriting an unchanged body.
+func (vw *viewEntry) render(w http.ResponseWriter, r *http.Request, execFn func(io.Writer) error) {
+	maxAge := vw.v.cfg.CachePolicy.Views
+	if devMode || maxAge <= 0 {
+		if err := execFn(w); err != nil {
+			slog.DebugContext(r.Context(), "failed to execute template", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := execFn(&buf); err != nil {
+		slog.DebugContext(r.Context(), "failed to execute template", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	etag := contentETag(buf.Bytes())
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", maxAge))
+
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	_, _ = w.Write(buf.Bytes())
+}
+
+func (vw *viewEntry) exec(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", vw.contentType)
+
+	data := vw.data(r)
+	t := vw.template()
+
+	if fragments, ok := data.([]NamedFragment); ok {
+		vw.render(w, r, func(out io.Writer) error {
+			for _, f := range fragments {
+				if err := t.ExecuteTemplate(out, f.Name, f.Data); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		return
+	}
+
+	if name := fragmentName(r); name != "" {
+		vw.render(w, r, func(out io.Writer) error {
+			return t.ExecuteTemplate(out, name, data)
+		})
+		return
+	}
+
+	if vw.execName != inner {
+		vw.render(w, r, func(out io.Writer) error {
+			return t.ExecuteTemplate(out, vw.execName, data)
+		})
+		return
+	}
+
+	vw.render(w, r, func(out io.Writer) error {
+		var body strings.Builder
+		if err := t.ExecuteTemplate(&body, "body", data); err != nil {
+			return err
+		}
+
+		chrome := struct {
+			Theme string
+			Body  template.HTML
+		}{Theme: vw.v.activeTheme(r), Body: template.HTML(body.String())}
+
+		return t.ExecuteTemplate(out, inner, chrome)
+	})
 }
 
 func (v *viewer) handler(w http.ResponseWriter, r *http.Request) {
 	if r.Header.Get("X-Content-Request") != "true" {
-		v.views[main](w, r)
+		v.views[main].exec(w, r)
 		return
 	}
 
 	key := r.URL.Query().Get(view)
-	if proc, ok := v.views[key]; ok {
-		proc(w, r)
+	if vw, ok := v.views[key]; ok {
+		vw.exec(w, r)
 		return
 	}
 
@@ -126,16 +390,18 @@ func (v *viewer) handler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (v *viewer) addView(name string, parse func(*template.Template), data func(*http.Request) any) {
-	t := template.Must(template.New(inner).Funcs(v.cfg.FuncMap).Parse(v.inner))
-	parse(t)
-
-	v.views[name] = func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "text/html; charset=utf-8")
-		if err := t.ExecuteTemplate(w, inner, data(r)); err != nil {
-			slog.DebugContext(r.Context(), "failed to execute template", "error", err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-		}
+	build := func() templateExecutor {
+		t := template.Must(v.cloneBase().Parse(v.inner))
+		parse(t)
+		return t
 	}
+
+	v.registerView(name, build, inner, "text/html; charset=utf-8", data)
+}
+
+func (v *viewer) registerView(name string, build func() templateExecutor, execName, contentType string, data func(*http.Request) any) {
+	vw := &viewEntry{build: build, execName: execName, contentType: contentType, data: data, v: v}
+	v.views[name] = vw
 }
 
 func (v *viewer) addMainView() {
@@ -156,15 +422,34 @@ func (v *viewer) AddView(name, tmpl string, data func(*http.Request) any) {
 	v.addView(name, func(t *template.Template) { template.Must(t.Parse(tmpl)) }, data)
 }
 
+func (v *viewer) AddTextView(name, contentType, tmpl string, data func(*http.Request) any) {
+	v.sources[name] = data
+
+	build := func() templateExecutor {
+		return texttemplate.Must(texttemplate.New(name).Funcs(texttemplate.FuncMap(v.cfg.FuncMap)).Parse(tmpl))
+	}
+
+	v.registerView(name, build, name, contentType, data)
+}
+
 func (v *viewer) AddSource(name string, data func(*http.Request) any) {
 	v.sources[name] = data
 }
 
+func (v *viewer) AddTheme(name string, fsys fs.FS) {
+	v.themes[name] = fsys
+}
+
 func (v *viewer) addTempView() {
-	entries := loader(v.templates.ReadDir, "templates", ".gohtml")
+	entries := loader(v.templates, "templates", ".gohtml")
 
 	for _, entry := range entries {
-		name := strings.TrimSuffix(path.Base(entry), path.Ext(entry))
+		base := path.Base(entry)
+		if strings.HasPrefix(base, "_") {
+			continue
+		}
+
+		name := strings.TrimSuffix(base, path.Ext(base))
 
 		data, ok := v.sources[name]
 		if !ok {
@@ -177,11 +462,69 @@ func (v *viewer) addTempView() {
 	}
 }
 
+func withCache(maxAge int, h http.Handler) http.Handler {
+	if devMode || maxAge <= 0 {
+		return h
+	}
+
+	cacheControl := fmt.Sprintf("public, max-age=%d", maxAge)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", cacheControl)
+		h.ServeHTTP(w, r)
+	})
+}
+
+// fileServer serves fsys (stripping prefix from the request path first, if
+// given) and, when maxAge is set, adds a content-hash ETag alongside
+// Cache-Control, the same caching treatment addView gives rendered views.
+func fileServer(fsys fs.FS, prefix string, maxAge int) http.Handler {
+	var h http.Handler = http.FileServer(http.FS(fsys))
+	if prefix != "" {
+		h = http.StripPrefix(prefix, h)
+	}
+
+	if devMode || maxAge <= 0 {
+		return h
+	}
+
+	cacheControl := fmt.Sprintf("public, max-age=%d", maxAge)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(path.Clean(strings.TrimPrefix(r.URL.Path, prefix)), "/")
+		if b, err := fs.ReadFile(fsys, name); err == nil {
+			etag := contentETag(b)
+			w.Header().Set("ETag", etag)
+			if r.Header.Get("If-None-Match") == etag {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+
+		w.Header().Set("Cache-Control", cacheControl)
+		h.ServeHTTP(w, r)
+	})
+}
+
 func (v *viewer) Inject(router *http.ServeMux) {
 	v.addMainView()
 	v.addTempView()
 
-	faviconHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	// Templates are built here, not at registration time, so AddPartial and
+	// AddFuncMap calls are visible to every view regardless of whether they
+	// come before or after the AddView/AddTextView call they apply to. Text
+	// views get the func map only; partials are not available to them.
+	if !devMode {
+		for _, vw := range v.views {
+			vw.tmpl = vw.build()
+		}
+	}
+
+	faviconMaxAge := v.cfg.CachePolicy.Static
+	if faviconMaxAge <= 0 {
+		faviconMaxAge = 86400
+	}
+
+	faviconHandler := withCache(faviconMaxAge, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		f, err := v.static.Open("static/favicon.ico")
 		if err != nil {
 			w.WriteHeader(http.StatusNoContent)
@@ -189,12 +532,16 @@ func (v *viewer) Inject(router *http.ServeMux) {
 		}
 		_ = f.Close()
 
-		w.Header().Set("Cache-Control", "public, max-age=86400")
 		http.ServeFileFS(w, r, v.static, "static/favicon.ico")
-	})
+	}))
 
 	router.HandleFunc("/"+v.cfg.Path, v.handler)
 	router.Handle("/favicon.ico", faviconHandler)
-	router.Handle("/static/", http.FileServer(http.FS(v.static)))
-	router.Handle("/viewkit/", http.StripPrefix("/viewkit/", http.FileServer(http.FS(viewkit))))
+	router.Handle("/static/", fileServer(v.static, "", v.cfg.CachePolicy.Static))
+	router.Handle("/viewkit/", fileServer(viewkit, "/viewkit/", v.cfg.CachePolicy.Internal))
+
+	for name, fsys := range v.themes {
+		prefix := themepath(name) + "/"
+		router.Handle(prefix, fileServer(fsys, prefix, v.cfg.CachePolicy.Static))
+	}
 }
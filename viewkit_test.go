@@ -0,0 +1,218 @@
+package viewkit
+
+import (
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// newRouter builds a viewer and injects it, calling register (if given) in
+// between so views/partials/func maps are visible to each other the same
+// way they would be in the documented New -> AddView(s) -> Inject order.
+func newRouter(t *testing.T, cfg Configuration, static, templates fs.FS, register func(Viewer)) (Viewer, *http.ServeMux) {
+	t.Helper()
+	v := New(cfg, static, templates)
+	if register != nil {
+		register(v)
+	}
+	mux := http.NewServeMux()
+	v.Inject(mux)
+	return v, mux
+}
+
+func TestMainViewDispatch(t *testing.T) {
+	if devMode {
+		t.Fatal("devMode must be false in a default (non -tags dev) build")
+	}
+
+	templates := fstest.MapFS{
+		"templates/home.gohtml": {Data: []byte(`{{ define "body" }}hello{{ end }}`)},
+	}
+
+	_, mux := newRouter(t, Configuration{Path: "app", StartView: "home"}, fstest.MapFS{}, templates, nil)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/app", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+}
+
+func TestAddViewAcceptsMapFS(t *testing.T) {
+	static := fstest.MapFS{
+		"static/theme.css": {Data: []byte("body{color:red}")},
+	}
+
+	_, mux := newRouter(t, Configuration{Path: "app", StartView: "home"}, static, fstest.MapFS{}, func(v Viewer) {
+		v.AddView("home", `{{ define "body" }}hi{{ end }}`, func(r *http.Request) any { return nil })
+	})
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/app", nil)
+	req.Header.Set("X-Content-Request", "true")
+	req.URL.RawQuery = "view=home"
+	mux.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), "static/theme.css") {
+		t.Fatalf("body missing stylesheet link for fstest.MapFS-backed static: %s", rec.Body.String())
+	}
+}
+
+func TestAddTextViewSkipsChrome(t *testing.T) {
+	_, mux := newRouter(t, Configuration{Path: "app", StartView: "home"}, fstest.MapFS{}, fstest.MapFS{}, func(v Viewer) {
+		v.AddTextView("report.csv", "text/csv", `id,name{{ "\n" }}1,a`, func(r *http.Request) any { return nil })
+	})
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/app", nil)
+	req.Header.Set("X-Content-Request", "true")
+	req.URL.RawQuery = "view=report.csv"
+	mux.ServeHTTP(rec, req)
+
+	if ct := rec.Header().Get("Content-Type"); ct != "text/csv" {
+		t.Fatalf("Content-Type = %q, want text/csv", ct)
+	}
+	if strings.Contains(rec.Body.String(), "<html") {
+		t.Fatalf("non-HTML view should not be wrapped in chrome: %s", rec.Body.String())
+	}
+	if rec.Body.String() != "id,name\n1,a" {
+		t.Fatalf("body = %q", rec.Body.String())
+	}
+}
+
+func TestThemeSwitching(t *testing.T) {
+	cfg := Configuration{
+		Path:         "app",
+		StartView:    "home",
+		Themes:       []string{"light", "dark"},
+		DefaultTheme: "light",
+	}
+
+	_, mux := newRouter(t, cfg, fstest.MapFS{}, fstest.MapFS{}, func(v Viewer) {
+		v.AddView("home", `{{ define "body" }}hi{{ end }}`, func(r *http.Request) any { return nil })
+	})
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/app", nil)
+	req.Header.Set("X-Content-Request", "true")
+	req.URL.RawQuery = "view=home&theme=dark"
+	mux.ServeHTTP(rec, req)
+
+	if !strings.Contains(rec.Body.String(), `data-theme="dark"`) {
+		t.Fatalf("expected data-theme=dark, got: %s", rec.Body.String())
+	}
+}
+
+func TestFragmentDispatch(t *testing.T) {
+	_, mux := newRouter(t, Configuration{Path: "app", StartView: "home"}, fstest.MapFS{}, fstest.MapFS{}, func(v Viewer) {
+		v.AddView("home", `{{ define "body" }}full{{ end }}{{ define "fragment-foo" }}frag{{ end }}`, func(r *http.Request) any { return nil })
+	})
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/app", nil)
+	req.Header.Set("X-Content-Request", "true")
+	req.Header.Set(fragmentHeader, "fragment-foo")
+	req.URL.RawQuery = "view=home"
+	mux.ServeHTTP(rec, req)
+
+	if rec.Body.String() != "frag" {
+		t.Fatalf("fragment body = %q, want %q", rec.Body.String(), "frag")
+	}
+}
+
+func TestNamedFragmentOutOfBand(t *testing.T) {
+	_, mux := newRouter(t, Configuration{Path: "app", StartView: "home"}, fstest.MapFS{}, fstest.MapFS{}, func(v Viewer) {
+		v.AddView("home", `{{ define "sidebar" }}side{{ end }}{{ define "main" }}main{{ end }}`, func(r *http.Request) any {
+			return []NamedFragment{{Name: "sidebar"}, {Name: "main"}}
+		})
+	})
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/app", nil)
+	req.Header.Set("X-Content-Request", "true")
+	req.URL.RawQuery = "view=home"
+	mux.ServeHTTP(rec, req)
+
+	if rec.Body.String() != "sidemain" {
+		t.Fatalf("body = %q, want %q", rec.Body.String(), "sidemain")
+	}
+}
+
+func TestViewETagNotModified(t *testing.T) {
+	cfg := Configuration{Path: "app", StartView: "home", CachePolicy: CachePolicy{Views: 60}}
+	_, mux := newRouter(t, cfg, fstest.MapFS{}, fstest.MapFS{}, func(v Viewer) {
+		v.AddView("home", `{{ define "body" }}stable{{ end }}`, func(r *http.Request) any { return nil })
+	})
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/app", nil)
+	req.Header.Set("X-Content-Request", "true")
+	req.URL.RawQuery = "view=home"
+	mux.ServeHTTP(rec, req)
+
+	etag := rec.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("expected ETag header to be set")
+	}
+
+	rec2 := httptest.NewRecorder()
+	req2 := httptest.NewRequest(http.MethodGet, "/app", nil)
+	req2.Header.Set("X-Content-Request", "true")
+	req2.Header.Set("If-None-Match", etag)
+	req2.URL.RawQuery = "view=home"
+	mux.ServeHTTP(rec2, req2)
+
+	if rec2.Code != http.StatusNotModified {
+		t.Fatalf("status = %d, want 304", rec2.Code)
+	}
+}
+
+func TestAddViewSeesFilePartials(t *testing.T) {
+	templates := fstest.MapFS{
+		"templates/_nav.gohtml": {Data: []byte(`nav`)},
+	}
+
+	_, mux := newRouter(t, Configuration{Path: "app", StartView: "home"}, fstest.MapFS{}, templates, func(v Viewer) {
+		v.AddView("home", `{{ define "body" }}{{ template "_nav" . }}-body{{ end }}`, func(r *http.Request) any { return nil })
+	})
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/app", nil)
+	req.Header.Set("X-Content-Request", "true")
+	req.URL.RawQuery = "view=home"
+	mux.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200, body: %s", rec.Code, rec.Body.String())
+	}
+	if !strings.Contains(rec.Body.String(), "nav-body") {
+		t.Fatalf("body missing rendered partial: %s", rec.Body.String())
+	}
+}
+
+func TestAddPartialAfterAddView(t *testing.T) {
+	_, mux := newRouter(t, Configuration{Path: "app", StartView: "home"}, fstest.MapFS{}, fstest.MapFS{}, func(v Viewer) {
+		v.AddView("home", `{{ define "body" }}{{ template "footer" . }}-body{{ end }}`, func(r *http.Request) any { return nil })
+		v.AddPartial("footer", "FOOTER")
+	})
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/app", nil)
+	req.Header.Set("X-Content-Request", "true")
+	req.URL.RawQuery = "view=home"
+	mux.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200, body: %s", rec.Code, rec.Body.String())
+	}
+	if !strings.Contains(rec.Body.String(), "FOOTER-body") {
+		t.Fatalf("body missing rendered partial: %s", rec.Body.String())
+	}
+}